@@ -0,0 +1,153 @@
+// Package ndkdetect detects the `android.ndkVersion` declared by a Gradle project, so the step can install
+// the right NDK without the version having to be duplicated in the step's inputs.
+package ndkdetect
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const androidNDKVersionEnvKey = "ANDROID_NDK_VERSION"
+
+var (
+	ndkVersionLiteralPattern = regexp.MustCompile(`ndkVersion\s*=?\s*"([^"]+)"`)
+	ndkVersionCatalogPattern = regexp.MustCompile(`ndkVersion\s*=?\s*libs\.versions\.([A-Za-z0-9_.-]+)\.get\(\)`)
+)
+
+// Detect inspects the Gradle project rooted next to gradlewPath for an `android.ndkVersion` declaration
+// (root and module level build.gradle/build.gradle.kts files, e.g. app/build.gradle) and returns the
+// declared version. If no module declares a version, the ANDROID_NDK_VERSION environment variable is
+// returned as a fallback. An empty string is returned when neither source yields a version.
+//
+// An error is returned when multiple modules declare conflicting ndkVersion values, since picking one
+// silently would likely produce a build that doesn't match what the project actually ships with.
+func Detect(gradlewPath string) (string, error) {
+	projectRoot := filepath.Dir(gradlewPath)
+
+	buildFiles, err := gradleBuildFiles(projectRoot)
+	if err != nil {
+		return "", err
+	}
+
+	declaredBy := map[string]string{} // build file path -> declared version
+	for _, buildFile := range buildFiles {
+		declaredVersion, err := ndkVersionFromBuildFile(buildFile, projectRoot)
+		if err != nil {
+			return "", err
+		}
+		if declaredVersion != "" {
+			declaredBy[buildFile] = declaredVersion
+		}
+	}
+
+	switch versions := distinctValues(declaredBy); len(versions) {
+	case 0:
+		return os.Getenv(androidNDKVersionEnvKey), nil
+	case 1:
+		return versions[0], nil
+	default:
+		return "", fmt.Errorf("conflicting android.ndkVersion declarations found:\n%s", describeConflict(declaredBy))
+	}
+}
+
+// gradleBuildFiles returns the root project's and any direct submodule's Gradle build files.
+func gradleBuildFiles(projectRoot string) ([]string, error) {
+	patterns := []string{
+		filepath.Join(projectRoot, "build.gradle"),
+		filepath.Join(projectRoot, "build.gradle.kts"),
+		filepath.Join(projectRoot, "*", "build.gradle"),
+		filepath.Join(projectRoot, "*", "build.gradle.kts"),
+	}
+
+	var buildFiles []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		buildFiles = append(buildFiles, matches...)
+	}
+
+	sort.Strings(buildFiles)
+	return buildFiles, nil
+}
+
+// ndkVersionFromBuildFile returns the ndkVersion declared in buildFile, tolerating both
+// `ndkVersion "25.1.8937393"` and `ndkVersion = "25.1.8937393"` syntax, as well as version catalog
+// references like `ndkVersion = libs.versions.ndk.get()`. Returns an empty string if the file declares no
+// ndkVersion.
+func ndkVersionFromBuildFile(buildFile, projectRoot string) (string, error) {
+	content, err := os.ReadFile(buildFile)
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if idx := strings.Index(line, "//"); idx >= 0 {
+			line = line[:idx]
+		}
+		if !strings.Contains(line, "ndkVersion") {
+			continue
+		}
+
+		if match := ndkVersionLiteralPattern.FindStringSubmatch(line); match != nil {
+			return match[1], nil
+		}
+		if match := ndkVersionCatalogPattern.FindStringSubmatch(line); match != nil {
+			return versionCatalogEntry(filepath.Join(projectRoot, "gradle", "libs.versions.toml"), match[1])
+		}
+	}
+
+	return "", nil
+}
+
+// versionCatalogEntry looks up key in the [versions] table of a Gradle version catalog TOML file.
+func versionCatalogEntry(catalogPath, key string) (string, error) {
+	content, err := os.ReadFile(catalogPath)
+	if err != nil {
+		return "", fmt.Errorf("ndkVersion references version catalog entry 'libs.versions.%s.get()' but %s could not be read: %s", key, catalogPath, err)
+	}
+
+	// The [versions] table runs from its header up to the next table header (or the end of the file).
+	versionsTable := ""
+	if header := regexp.MustCompile(`(?m)^[ \t]*\[versions\][ \t]*$`).FindStringIndex(string(content)); header != nil {
+		versionsTable = string(content)[header[1]:]
+		if next := regexp.MustCompile(`(?m)^[ \t]*\[`).FindStringIndex(versionsTable); next != nil {
+			versionsTable = versionsTable[:next[0]]
+		}
+	}
+
+	pattern := regexp.MustCompile(fmt.Sprintf(`(?m)^\s*%s\s*=\s*"([^"]+)"`, regexp.QuoteMeta(key)))
+	match := pattern.FindStringSubmatch(versionsTable)
+	if match == nil {
+		return "", fmt.Errorf("version catalog entry 'libs.versions.%s' not found in %s", key, catalogPath)
+	}
+
+	return match[1], nil
+}
+
+func distinctValues(byFile map[string]string) []string {
+	seen := map[string]bool{}
+	var values []string
+	for _, v := range byFile {
+		if !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+func describeConflict(declaredBy map[string]string) string {
+	var lines []string
+	for buildFile, v := range declaredBy {
+		lines = append(lines, fmt.Sprintf("- %s: %s", buildFile, v))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,109 @@
+package ndkdetect
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFixtureProject(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for relPath, content := range files {
+		absPath := filepath.Join(root, relPath)
+		if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+			t.Fatalf("failed to create fixture dir: %s", err)
+		}
+		if err := os.WriteFile(absPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write fixture file: %s", err)
+		}
+	}
+
+	return filepath.Join(root, "gradlew")
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "groovy module-level build.gradle, quoted form",
+			files: map[string]string{
+				"app/build.gradle": "android {\n    ndkVersion \"25.1.8937393\"\n}\n",
+			},
+			want: "25.1.8937393",
+		},
+		{
+			name: "groovy module-level build.gradle, assignment form",
+			files: map[string]string{
+				"app/build.gradle": "android {\n    ndkVersion = \"25.1.8937393\" // pinned for NDK samples\n}\n",
+			},
+			want: "25.1.8937393",
+		},
+		{
+			name: "kotlin root build.gradle.kts",
+			files: map[string]string{
+				"build.gradle.kts": "android {\n    ndkVersion = \"23.0.7599858\"\n}\n",
+			},
+			want: "23.0.7599858",
+		},
+		{
+			name: "version catalog reference",
+			files: map[string]string{
+				"app/build.gradle.kts":      "android {\n    ndkVersion = libs.versions.ndk.get()\n}\n",
+				"gradle/libs.versions.toml": "[versions]\nndk = \"26.1.10909125\"\n",
+			},
+			want: "26.1.10909125",
+		},
+		{
+			name: "no declaration, falls back to ANDROID_NDK_VERSION",
+			files: map[string]string{
+				"app/build.gradle": "android {\n    compileSdk 34\n}\n",
+			},
+			env:  map[string]string{"ANDROID_NDK_VERSION": "24.0.8215888"},
+			want: "24.0.8215888",
+		},
+		{
+			name:  "no declaration and no env fallback",
+			files: map[string]string{"app/build.gradle": "android {\n    compileSdk 34\n}\n"},
+			want:  "",
+		},
+		{
+			name: "conflicting declarations fail",
+			files: map[string]string{
+				"build.gradle":     "android {\n    ndkVersion \"23.0.7599858\"\n}\n",
+				"app/build.gradle": "android {\n    ndkVersion \"25.1.8937393\"\n}\n",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			gradlewPath := writeFixtureProject(t, test.files)
+
+			got, err := Detect(gradlewPath)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != test.want {
+				t.Errorf("Detect() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/bitrise-io/go-android/sdk"
@@ -15,7 +17,9 @@ import (
 	"github.com/bitrise-io/go-utils/env"
 	"github.com/bitrise-io/go-utils/fileutil"
 	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-steplib/steps-install-missing-android-tools/androidcomponents"
+	"github.com/bitrise-steplib/steps-install-missing-android-tools/androidcomponents/ndkdetect"
 	"github.com/hashicorp/go-version"
 )
 
@@ -23,10 +27,12 @@ const androidNDKHome = "ANDROID_NDK_HOME"
 
 // Config ...
 type Config struct {
-	GradlewPath    string `env:"gradlew_path,file"`
-	AndroidHome    string `env:"ANDROID_HOME"`
-	AndroidSDKRoot string `env:"ANDROID_SDK_ROOT"`
-	NDKVersion     string `env:"ndk_version"`
+	GradlewPath     string `env:"gradlew_path,file"`
+	AndroidHome     string `env:"ANDROID_HOME"`
+	AndroidSDKRoot  string `env:"ANDROID_SDK_ROOT"`
+	NDKVersion      string `env:"ndk_version"`
+	KeepExistingNDK bool   `env:"keep_existing_ndk,opt[true,false]"`
+	Packages        string `env:"packages"`
 }
 
 var logger = log.NewLogger()
@@ -57,7 +63,163 @@ func ndkVersion(ndkPath string) string {
 	return ""
 }
 
-func currentNDKHome() string {
+// resolvedNDK describes the outcome of resolving a requested NDK version (or constraint) to a concrete
+// version, along with its install location when it is already present on disk.
+type resolvedNDK struct {
+	Version       string
+	InstalledPath string
+}
+
+// resolveNDKVersion resolves the ndk_version input to a concrete NDK version. The input can either be an
+// exact version (e.g. "23.0.7599858"), in which case only that version is acceptable, or a go-version
+// constraint expression (e.g. ">=23.1, <26", "~> 25.1", "25.x"). It first looks for the highest NDK
+// DiscoverNDKs finds on disk that satisfies the request. If none is found, an exact version is returned as
+// is for sdkmanager to install, and a constraint expression is resolved to the highest matching version
+// sdkmanager has available remotely.
+func resolveNDKVersion(rawVersion string, androidSdk *sdk.Model) (resolvedNDK, error) {
+	constraints, err := ndkVersionConstraints(rawVersion)
+	if err != nil {
+		return resolvedNDK{}, err
+	}
+
+	if best := highestMatchingCandidate(DiscoverNDKs(androidSdk), constraints); best != nil {
+		logger.Printf("Found installed NDK %s satisfying %s", best.Version, rawVersion)
+		return resolvedNDK{Version: best.Version.String(), InstalledPath: best.Path}, nil
+	}
+
+	if _, err := version.NewVersion(rawVersion); err == nil {
+		// rawVersion is an exact version, not a constraint expression: hand it straight to sdkmanager
+		// instead of requiring it to show up in `sdkmanager --list`, so installs still succeed if the
+		// package index lags behind a real release (e.g. a just-published NDK version).
+		return resolvedNDK{Version: rawVersion}, nil
+	}
+
+	remoteVersion, err := highestAvailableNDKMatching(androidSdk, constraints)
+	if err != nil {
+		return resolvedNDK{}, err
+	}
+	if remoteVersion == nil {
+		return resolvedNDK{}, fmt.Errorf("no NDK version available matching %s", rawVersion)
+	}
+	logger.Printf("Resolved %s to NDK %s", rawVersion, remoteVersion)
+
+	return resolvedNDK{Version: remoteVersion.String()}, nil
+}
+
+// ndkVersionConstraints parses rawVersion into constraints that can be checked against discovered or
+// remotely available NDK versions. An exact version (e.g. "23.0.7599858") is treated as a constraint that
+// only that version satisfies; a version prefix with a trailing ".x" wildcard (e.g. "25.x", "25.1.x") is
+// treated as the matching pessimistic constraint ("~> 25.0", "~> 25.1.0"); anything else is parsed as a
+// go-version constraint expression.
+func ndkVersionConstraints(rawVersion string) (version.Constraints, error) {
+	if _, err := version.NewVersion(rawVersion); err == nil {
+		return version.NewConstraint("= " + rawVersion)
+	}
+
+	if prefix := strings.TrimSuffix(rawVersion, ".x"); prefix != rawVersion {
+		if _, err := version.NewVersion(prefix); err == nil {
+			return version.NewConstraint("~> " + prefix + ".0")
+		}
+	}
+
+	constraints, err := version.NewConstraint(rawVersion)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is neither a valid NDK version nor a valid version constraint: %s", rawVersion, err)
+	}
+	return constraints, nil
+}
+
+// highestMatchingCandidate returns the highest-versioned candidate satisfying constraints, or nil if none
+// match.
+func highestMatchingCandidate(candidates []NDKCandidate, constraints version.Constraints) *NDKCandidate {
+	var best *NDKCandidate
+	for _, candidate := range candidates {
+		candidate := candidate
+		if !constraints.Check(candidate.Version) {
+			continue
+		}
+		if best == nil || candidate.Version.GreaterThan(best.Version) {
+			best = &candidate
+		}
+	}
+	return best
+}
+
+// sdkManagerPath resolves the sdkmanager binary path the same way sdkmanager.New does: the cmdline-tools
+// `sdkmanager` binary if present, falling back to the legacy `android` tool.
+func sdkManagerPath(androidSdk *sdk.Model) (string, error) {
+	cmdlineToolsPath, err := androidSdk.CmdlineToolsPath()
+	if err != nil {
+		return "", err
+	}
+
+	sdkManagerPath := filepath.Join(cmdlineToolsPath, "sdkmanager")
+	if exist, err := pathutil.IsPathExists(sdkManagerPath); err != nil {
+		return "", err
+	} else if exist {
+		return sdkManagerPath, nil
+	}
+
+	legacySdkManagerPath := filepath.Join(cmdlineToolsPath, "android")
+	if exist, err := pathutil.IsPathExists(legacySdkManagerPath); err != nil {
+		return "", err
+	} else if exist {
+		return legacySdkManagerPath, nil
+	}
+
+	return "", fmt.Errorf("no sdkmanager tool found at: %s", sdkManagerPath)
+}
+
+// sdkManagerListOutput runs `sdkmanager --list` and returns its trimmed output.
+func sdkManagerListOutput(androidSdk *sdk.Model) (string, error) {
+	binPath, err := sdkManagerPath(androidSdk)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := cmdFactory.Create(binPath, []string{"--list"}, nil)
+	output, err := cmd.RunAndReturnTrimmedOutput()
+	if err != nil {
+		logger.Errorf(output)
+		return "", err
+	}
+	return output, nil
+}
+
+// highestAvailableNDKMatching queries `sdkmanager --list` for remotely available NDK packages and returns
+// the highest version satisfying constraints, or a nil version if none match.
+func highestAvailableNDKMatching(androidSdk *sdk.Model, constraints version.Constraints) (*version.Version, error) {
+	output, err := sdkManagerListOutput(androidSdk)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*version.Version
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "ndk;") {
+			continue
+		}
+
+		parsedVersion, err := version.NewVersion(strings.TrimPrefix(fields[0], "ndk;"))
+		if err != nil {
+			continue
+		}
+		if constraints.Check(parsedVersion) {
+			candidates = append(candidates, parsedVersion)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sort.Sort(sort.Reverse(version.Collection(candidates)))
+	return candidates[0], nil
+}
+
+// legacyNDKBundleHome returns the pre-side-by-side NDK install location, used only to decide what to purge
+// when keep_existing_ndk is disabled. General NDK discovery is handled by DiscoverNDKs.
+func legacyNDKBundleHome() string {
 	if v := os.Getenv(androidNDKHome); v != "" {
 		return v
 	}
@@ -75,35 +237,140 @@ func currentNDKHome() string {
 	return "ndk-bundle"
 }
 
-// updateNDK installs the requested NDK version (if not already installed to the correct location).
-// NDK is installed to the `ndk/version` subdirectory of the SDK location, while updating $ANDROID_NDK_HOME for
-// compatibility with older Android Gradle Plugin versions.
-// Details: https://github.com/android/ndk-samples/wiki/Configure-NDK-Path
-func updateNDK(version string, androidSdk *sdk.Model) error {
-	currentNdkHome := currentNDKHome()
+// NDKCandidate describes an NDK installation discovered on disk.
+type NDKCandidate struct {
+	Path        string
+	Version     *version.Version
+	ABIs        []string
+	MinPlatform int
+	MaxPlatform int
+}
+
+// DiscoverNDKs returns every NDK installation it can find under the locations NDKs are typically installed
+// to: $ANDROID_NDK_HOME, $ANDROID_NDK_ROOT, the side-by-side ndk/<version> directories under
+// $ANDROID_HOME / $ANDROID_SDK_ROOT, the legacy ndk-bundle directories, and the locations system packages
+// use (/usr/lib/android-ndk on Debian/Ubuntu, /opt/android-ndk on Arch). Each discovered candidate is
+// logged so it's clear why a particular NDK was chosen.
+func DiscoverNDKs(androidSdk *sdk.Model) []NDKCandidate {
+	var searchPaths []string
+	searchPaths = append(searchPaths, os.Getenv(androidNDKHome), os.Getenv("ANDROID_NDK_ROOT"))
+
+	for _, sdkRoot := range []string{androidSdk.GetAndroidHome(), os.Getenv("ANDROID_SDK_ROOT")} {
+		if sdkRoot == "" {
+			continue
+		}
+		searchPaths = append(searchPaths, filepath.Join(sdkRoot, "ndk-bundle"))
+		if matches, err := filepath.Glob(filepath.Join(sdkRoot, "ndk", "*")); err == nil {
+			searchPaths = append(searchPaths, matches...)
+		}
+	}
+
+	searchPaths = append(searchPaths, "/usr/lib/android-ndk", "/opt/android-ndk")
 
-	currentVersion := ndkVersion(currentNdkHome)
-	if currentVersion == version {
-		logger.Donef("NDK %s already installed at %s", version, currentNdkHome)
+	var candidates []NDKCandidate
+	seenPaths := map[string]bool{}
+	for _, path := range searchPaths {
+		if path == "" || seenPaths[path] {
+			continue
+		}
+		seenPaths[path] = true
+
+		parsedVersion, err := version.NewVersion(ndkVersion(path))
+		if err != nil {
+			continue
+		}
+
+		candidate := NDKCandidate{
+			Path:    path,
+			Version: parsedVersion,
+			ABIs:    ndkABIs(path),
+		}
+		candidate.MinPlatform, candidate.MaxPlatform = ndkPlatformRange(path)
+		candidates = append(candidates, candidate)
+
+		logger.Printf("Discovered NDK %s at %s (ABIs: %s, platforms: %d-%d)", candidate.Version, candidate.Path, strings.Join(candidate.ABIs, ", "), candidate.MinPlatform, candidate.MaxPlatform)
+	}
+
+	return candidates
+}
+
+// ndkABIs returns the ABI names an NDK install supports, read from its meta/abis.json.
+func ndkABIs(ndkPath string) []string {
+	content, err := os.ReadFile(filepath.Join(ndkPath, "meta", "abis.json"))
+	if err != nil {
 		return nil
 	}
 
-	if currentVersion != "" {
-		logger.Printf("NDK %s found at: %s", currentVersion, currentNdkHome)
+	var abis map[string]json.RawMessage
+	if err := json.Unmarshal(content, &abis); err != nil {
+		return nil
 	}
 
-	logger.Printf("Removing existing NDK...")
-	if err := os.RemoveAll(currentNdkHome); err != nil {
-		return err
+	names := make([]string, 0, len(abis))
+	for name := range abis {
+		names = append(names, name)
 	}
-	logger.Printf("Done")
+	sort.Strings(names)
 
-	logger.Printf("Installing NDK %s with sdkmanager", version)
+	return names
+}
+
+// ndkPlatformRange returns the minimum and maximum Android platform level an NDK install supports, read
+// from its meta/platforms.json.
+func ndkPlatformRange(ndkPath string) (min int, max int) {
+	content, err := os.ReadFile(filepath.Join(ndkPath, "meta", "platforms.json"))
+	if err != nil {
+		return 0, 0
+	}
+
+	var platforms struct {
+		Min int `json:"min"`
+		Max int `json:"max"`
+	}
+	if err := json.Unmarshal(content, &platforms); err != nil {
+		return 0, 0
+	}
+
+	return platforms.Min, platforms.Max
+}
+
+// updateNDK installs the resolved NDK version (if not already installed to the correct location).
+// NDK is installed to the `ndk/version` subdirectory of the SDK location, side-by-side with any other
+// previously installed versions, while updating $ANDROID_NDK_HOME for compatibility with older Android
+// Gradle Plugin versions. When keepExistingNDK is false, whatever NDK legacyNDKBundleHome() points at
+// (typically the legacy ndk-bundle) is purged first, matching the step's pre-side-by-side behavior.
+// Details: https://github.com/android/ndk-samples/wiki/Configure-NDK-Path
+func updateNDK(ndk resolvedNDK, androidSdk *sdk.Model, keepExistingNDK bool) error {
+	if ndk.InstalledPath != "" {
+		logger.Donef("NDK %s already installed at %s", ndk.Version, ndk.InstalledPath)
+		return exportNDKHome(ndk.InstalledPath)
+	}
+
+	ndkComponent := sdkcomponent.NDK{Version: ndk.Version}
+	targetNDKHome := filepath.Join(androidSdk.GetAndroidHome(), ndkComponent.InstallPathInAndroidHome())
+	if ndkVersion(targetNDKHome) == ndk.Version {
+		logger.Donef("NDK %s already installed at %s", ndk.Version, targetNDKHome)
+		return exportNDKHome(targetNDKHome)
+	}
+
+	if !keepExistingNDK {
+		legacyNdkHome := legacyNDKBundleHome()
+		if legacyVersion := ndkVersion(legacyNdkHome); legacyVersion != "" {
+			logger.Printf("NDK %s found at: %s", legacyVersion, legacyNdkHome)
+		}
+
+		logger.Printf("Removing existing NDK...")
+		if err := os.RemoveAll(legacyNdkHome); err != nil {
+			return err
+		}
+		logger.Printf("Done")
+	}
+
+	logger.Printf("Installing NDK %s with sdkmanager", ndk.Version)
 	sdkManager, err := sdkmanager.New(androidSdk, cmdFactory)
 	if err != nil {
 		return err
 	}
-	ndkComponent := sdkcomponent.NDK{Version: version}
 	cmd := sdkManager.InstallCommand(ndkComponent)
 	output, err := cmd.RunAndReturnTrimmedOutput()
 	if err != nil {
@@ -114,20 +381,166 @@ func updateNDK(version string, androidSdk *sdk.Model) error {
 
 	logger.Printf("Done")
 
+	return exportNDKHome(newNDKHome)
+}
+
+// exportNDKHome points $ANDROID_NDK_HOME and $PATH at the given NDK install location.
+func exportNDKHome(ndkHome string) error {
 	logger.Printf("Append NDK folder to $PATH")
-	// Old NDK folder was deleted above, its path can stay in $PATH
-	if err := tools.ExportEnvironmentWithEnvman("PATH", fmt.Sprintf("%s:%s", os.Getenv("PATH"), newNDKHome)); err != nil {
+	if err := tools.ExportEnvironmentWithEnvman("PATH", fmt.Sprintf("%s:%s", os.Getenv("PATH"), ndkHome)); err != nil {
+		return err
+	}
+
+	if err := tools.ExportEnvironmentWithEnvman(androidNDKHome, ndkHome); err != nil {
+		return err
+	}
+	logger.Printf("Exported $%s: %s", androidNDKHome, ndkHome)
+
+	return nil
+}
+
+// rawPackageComponent wraps a raw sdkmanager-style package coordinate (e.g. "build-tools;34.0.0") so it can
+// be installed through sdkmanager.Model.InstallCommand, which only accepts sdkcomponent.Model values.
+// Unlike the library's typed components, the coordinate is user-supplied, so there is no structured
+// install path or indicator file to report.
+type rawPackageComponent string
+
+// GetSDKStylePath ...
+func (component rawPackageComponent) GetSDKStylePath() string {
+	return string(component)
+}
+
+// GetLegacySDKStylePath ...
+func (component rawPackageComponent) GetLegacySDKStylePath() string {
+	return string(component)
+}
+
+// InstallPathInAndroidHome ...
+func (component rawPackageComponent) InstallPathInAndroidHome() string {
+	return ""
+}
+
+// InstallationIndicatorFile ...
+func (component rawPackageComponent) InstallationIndicatorFile() string {
+	return ""
+}
+
+// installPackages installs each sdkmanager-style package coordinate listed in rawPackages (newline
+// separated, e.g. "platforms;android-34", "build-tools;34.0.0", "cmdline-tools;latest", "cmake;3.22.1",
+// "system-images;android-30;google_apis;x86_64"). Each coordinate is validated against `sdkmanager --list`
+// first, failing fast with the closest known match if it isn't a real package.
+func installPackages(rawPackages string, androidSdk *sdk.Model) error {
+	packages := parsePackageList(rawPackages)
+	if len(packages) == 0 {
+		return nil
+	}
+
+	listOutput, err := sdkManagerListOutput(androidSdk)
+	if err != nil {
 		return err
 	}
+	available := availablePackageCoordinates(listOutput)
 
-	if err := tools.ExportEnvironmentWithEnvman(androidNDKHome, newNDKHome); err != nil {
+	sdkManager, err := sdkmanager.New(androidSdk, cmdFactory)
+	if err != nil {
 		return err
 	}
-	logger.Printf("Exported $%s: %s", androidNDKHome, newNDKHome)
+
+	for _, pkg := range packages {
+		if !available[pkg] {
+			return fmt.Errorf("unknown package '%s', did you mean '%s'? Run 'sdkmanager --list' to see all available packages", pkg, closestPackage(pkg, available))
+		}
+
+		logger.Printf("Installing %s with sdkmanager", pkg)
+		cmd := sdkManager.InstallCommand(rawPackageComponent(pkg))
+		output, err := cmd.RunAndReturnTrimmedOutput()
+		if err != nil {
+			logger.Errorf(output)
+			return err
+		}
+		logger.Donef("Installed %s", pkg)
+	}
 
 	return nil
 }
 
+// parsePackageList splits a newline separated packages input into trimmed, non-empty package coordinates.
+func parsePackageList(rawPackages string) []string {
+	var packages []string
+	for _, line := range strings.Split(rawPackages, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			packages = append(packages, line)
+		}
+	}
+	return packages
+}
+
+// availablePackageCoordinates parses the package coordinate (first column) out of each line of
+// `sdkmanager --list` output.
+func availablePackageCoordinates(listOutput string) map[string]bool {
+	available := map[string]bool{}
+	for _, line := range strings.Split(listOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.Contains(fields[0], ";") {
+			continue
+		}
+		available[fields[0]] = true
+	}
+	return available
+}
+
+// closestPackage returns the available package coordinate with the smallest Levenshtein distance to pkg,
+// for use in an error message when pkg isn't a known package.
+func closestPackage(pkg string, available map[string]bool) string {
+	var closest string
+	bestDistance := -1
+	for candidate := range available {
+		if distance := levenshteinDistance(pkg, candidate); bestDistance == -1 || distance < bestDistance {
+			bestDistance = distance
+			closest = candidate
+		}
+	}
+	return closest
+}
+
+// levenshteinDistance returns the edit distance between a and b.
+func levenshteinDistance(a, b string) int {
+	aRunes, bRunes := []rune(a), []rune(b)
+	previousRow := make([]int, len(bRunes)+1)
+	for i := range previousRow {
+		previousRow[i] = i
+	}
+
+	for i, aChar := range aRunes {
+		currentRow := make([]int, len(bRunes)+1)
+		currentRow[0] = i + 1
+		for j, bChar := range bRunes {
+			deletionCost := previousRow[j+1] + 1
+			insertionCost := currentRow[j] + 1
+			substitutionCost := previousRow[j]
+			if aChar != bChar {
+				substitutionCost++
+			}
+			currentRow[j+1] = min3(deletionCost, insertionCost, substitutionCost)
+		}
+		previousRow = currentRow
+	}
+
+	return previousRow[len(bRunes)]
+}
+
+func min3(a, b, c int) int {
+	m := a
+	if b < m {
+		m = b
+	}
+	if c < m {
+		m = c
+	}
+	return m
+}
+
 func main() {
 	// Input validation
 	var config Config
@@ -158,16 +571,27 @@ func main() {
 		failf("Failed to initialize Android SDK: %s", err)
 	}
 
+	if config.NDKVersion == "" {
+		detectedNDKVersion, err := ndkdetect.Detect(config.GradlewPath)
+		if err != nil {
+			failf("Failed to detect NDK version from the Gradle project: %s", err)
+		}
+		if detectedNDKVersion != "" {
+			logger.Printf("Detected ndkVersion %s from the Gradle project", detectedNDKVersion)
+			config.NDKVersion = detectedNDKVersion
+		}
+	}
+
 	fmt.Println()
 	if config.NDKVersion != "" {
 		logger.Infof("Installing Android NDK")
 
-		_, err := version.NewVersion(config.NDKVersion)
+		resolvedNDKVersion, err := resolveNDKVersion(config.NDKVersion, androidSdk)
 		if err != nil {
-			failf(fmt.Sprintf("'%s' is not a valid NDK version. This should be the full version number, such as 23.0.7599858. To see all available versions, run 'sdkmanager --list'", config.NDKVersion))
+			failf(fmt.Sprintf("Failed to resolve NDK version '%s', error: %s. This should either be the full version number, such as 23.0.7599858, or a constraint expression, such as '>=23.1, <26'.", config.NDKVersion, err))
 		}
 
-		if err := updateNDK(config.NDKVersion, androidSdk); err != nil {
+		if err := updateNDK(resolvedNDKVersion, androidSdk, config.KeepExistingNDK); err != nil {
 			failf("Failed to install new NDK package, error: %s", err)
 		}
 	} else {
@@ -190,6 +614,16 @@ func main() {
 		failf("Failed to ensure android licences, error: %s", err)
 	}
 
+	// Install pinned SDK packages
+	if config.Packages != "" {
+		fmt.Println()
+		logger.Infof("Installing pinned SDK packages")
+
+		if err := installPackages(config.Packages, androidSdk); err != nil {
+			failf("Failed to install pinned SDK packages, error: %s", err)
+		}
+	}
+
 	// Ensure required Android SDK components
 	fmt.Println()
 	logger.Infof("Ensure required Android SDK components")
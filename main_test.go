@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-android/sdk"
+	"github.com/hashicorp/go-version"
+)
+
+func TestResolveNDKVersion_ExactVersionSkipsRemoteLookup(t *testing.T) {
+	// An exact version with no matching NDK on disk must be handed straight to sdkmanager instead of
+	// being resolved through `sdkmanager --list`, so it still installs even if sdkmanager's package
+	// index doesn't (yet) surface it.
+	androidSdk, err := sdk.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create sdk: %s", err)
+	}
+
+	got, err := resolveNDKVersion("23.0.7599858", androidSdk)
+	if err != nil {
+		t.Fatalf("resolveNDKVersion() error = %s", err)
+	}
+	if got.Version != "23.0.7599858" {
+		t.Errorf("resolveNDKVersion().Version = %q, want %q", got.Version, "23.0.7599858")
+	}
+	if got.InstalledPath != "" {
+		t.Errorf("resolveNDKVersion().InstalledPath = %q, want empty", got.InstalledPath)
+	}
+}
+
+func TestResolveNDKVersion_InvalidConstraint(t *testing.T) {
+	androidSdk, err := sdk.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create sdk: %s", err)
+	}
+
+	if _, err := resolveNDKVersion("not a version", androidSdk); err == nil {
+		t.Fatalf("expected an error for an invalid version/constraint, got none")
+	}
+}
+
+func TestParsePackageList(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+	}{
+		{name: "empty", raw: "", want: nil},
+		{name: "blank lines only", raw: "\n  \n\t\n", want: nil},
+		{
+			name: "trims whitespace and drops blank lines",
+			raw:  "platforms;android-34\n  build-tools;34.0.0  \n\ncmdline-tools;latest\n",
+			want: []string{"platforms;android-34", "build-tools;34.0.0", "cmdline-tools;latest"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parsePackageList(test.raw)
+			if !stringSlicesEqual(got, test.want) {
+				t.Errorf("parsePackageList(%q) = %v, want %v", test.raw, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAvailablePackageCoordinates(t *testing.T) {
+	listOutput := strings.Join([]string{
+		"Installed packages:",
+		"  Path                | Version | Description",
+		"  build-tools;34.0.0  | 34.0.0  | Android SDK Build-Tools 34",
+		"Available Packages:",
+		"  platforms;android-34 | 3       | Android SDK Platform 34",
+		"  no-coordinate-here",
+	}, "\n")
+
+	got := availablePackageCoordinates(listOutput)
+
+	for _, coordinate := range []string{"build-tools;34.0.0", "platforms;android-34"} {
+		if !got[coordinate] {
+			t.Errorf("availablePackageCoordinates() missing %q", coordinate)
+		}
+	}
+	if got["no-coordinate-here"] {
+		t.Errorf("availablePackageCoordinates() unexpectedly included a line with no ';' coordinate")
+	}
+}
+
+func TestClosestPackage(t *testing.T) {
+	available := map[string]bool{
+		"build-tools;34.0.0":   true,
+		"platforms;android-34": true,
+		"ndk;25.1.8937393":     true,
+	}
+
+	got := closestPackage("build-tools;34.0.1", available)
+	if got != "build-tools;34.0.0" {
+		t.Errorf("closestPackage() = %q, want %q", got, "build-tools;34.0.0")
+	}
+}
+
+func TestLevenshteinDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "", b: "", want: 0},
+		{a: "abc", b: "abc", want: 0},
+		{a: "abc", b: "", want: 3},
+		{a: "kitten", b: "sitting", want: 3},
+		{a: "build-tools;34.0.0", b: "build-tools;34.0.1", want: 1},
+	}
+
+	for _, test := range tests {
+		got := levenshteinDistance(test.a, test.b)
+		if got != test.want {
+			t.Errorf("levenshteinDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNdkVersionConstraints(t *testing.T) {
+	tests := []struct {
+		name        string
+		rawVersion  string
+		matches     string
+		doesntMatch string
+		wantErr     bool
+	}{
+		{name: "exact version", rawVersion: "23.0.7599858", matches: "23.0.7599858", doesntMatch: "23.0.7599859"},
+		{name: "range constraint", rawVersion: ">=23.1, <26", matches: "25.1.8937393", doesntMatch: "26.0.0"},
+		{name: "invalid input", rawVersion: "not a version", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			constraints, err := ndkVersionConstraints(test.rawVersion)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !constraints.Check(version.Must(version.NewVersion(test.matches))) {
+				t.Errorf("constraints for %q should match %s", test.rawVersion, test.matches)
+			}
+			if constraints.Check(version.Must(version.NewVersion(test.doesntMatch))) {
+				t.Errorf("constraints for %q should not match %s", test.rawVersion, test.doesntMatch)
+			}
+		})
+	}
+}
+
+func TestHighestMatchingCandidate(t *testing.T) {
+	candidates := []NDKCandidate{
+		{Path: "v23", Version: version.Must(version.NewVersion("23.0.7599858"))},
+		{Path: "v25", Version: version.Must(version.NewVersion("25.1.8937393"))},
+		{Path: "v26", Version: version.Must(version.NewVersion("26.1.10909125"))},
+	}
+	constraints, err := version.NewConstraint(">=23.1, <26")
+	if err != nil {
+		t.Fatalf("failed to build constraint: %s", err)
+	}
+
+	got := highestMatchingCandidate(candidates, constraints)
+	if got == nil || got.Path != "v25" {
+		t.Errorf("highestMatchingCandidate() = %v, want the v25 candidate", got)
+	}
+
+	if got := highestMatchingCandidate(candidates, version.MustConstraints(version.NewConstraint(">=27"))); got != nil {
+		t.Errorf("highestMatchingCandidate() = %v, want nil", got)
+	}
+}
+
+func writeNDKFixture(t *testing.T, ndkPath string, ndkVersion string, abis []string, minPlatform, maxPlatform int) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Join(ndkPath, "meta"), 0755); err != nil {
+		t.Fatalf("failed to create NDK fixture dirs: %s", err)
+	}
+
+	sourceProperties := "Pkg.Desc = Android NDK\nPkg.Revision = " + ndkVersion + "\n"
+	if err := os.WriteFile(filepath.Join(ndkPath, "source.properties"), []byte(sourceProperties), 0644); err != nil {
+		t.Fatalf("failed to write source.properties: %s", err)
+	}
+
+	abisJSON := `{`
+	for i, abi := range abis {
+		if i > 0 {
+			abisJSON += ","
+		}
+		abisJSON += `"` + abi + `":{}`
+	}
+	abisJSON += `}`
+	if err := os.WriteFile(filepath.Join(ndkPath, "meta", "abis.json"), []byte(abisJSON), 0644); err != nil {
+		t.Fatalf("failed to write abis.json: %s", err)
+	}
+
+	platformsJSON := fmt.Sprintf(`{"min":%d,"max":%d}`, minPlatform, maxPlatform)
+	if err := os.WriteFile(filepath.Join(ndkPath, "meta", "platforms.json"), []byte(platformsJSON), 0644); err != nil {
+		t.Fatalf("failed to write platforms.json: %s", err)
+	}
+}
+
+func TestDiscoverNDKs(t *testing.T) {
+	androidHome := t.TempDir()
+	ndkPath := filepath.Join(androidHome, "ndk", "25.1.8937393")
+	writeNDKFixture(t, ndkPath, "25.1.8937393", []string{"arm64-v8a", "x86_64"}, 21, 34)
+
+	androidSdk, err := sdk.New(androidHome)
+	if err != nil {
+		t.Fatalf("failed to create sdk: %s", err)
+	}
+
+	candidates := DiscoverNDKs(androidSdk)
+
+	var found *NDKCandidate
+	for _, candidate := range candidates {
+		candidate := candidate
+		if candidate.Path == ndkPath {
+			found = &candidate
+		}
+	}
+	if found == nil {
+		t.Fatalf("DiscoverNDKs() did not find the fixture NDK at %s, got %v", ndkPath, candidates)
+	}
+	if found.Version.String() != "25.1.8937393" {
+		t.Errorf("found.Version = %s, want 25.1.8937393", found.Version)
+	}
+	if !stringSlicesEqual(found.ABIs, []string{"arm64-v8a", "x86_64"}) {
+		t.Errorf("found.ABIs = %v, want [arm64-v8a x86_64]", found.ABIs)
+	}
+	if found.MinPlatform != 21 || found.MaxPlatform != 34 {
+		t.Errorf("found platform range = %d-%d, want 21-34", found.MinPlatform, found.MaxPlatform)
+	}
+}
+
+func TestNdkABIsAndPlatformRange_MissingMeta(t *testing.T) {
+	ndkPath := t.TempDir()
+
+	if abis := ndkABIs(ndkPath); abis != nil {
+		t.Errorf("ndkABIs() = %v, want nil when meta/abis.json is missing", abis)
+	}
+	if min, max := ndkPlatformRange(ndkPath); min != 0 || max != 0 {
+		t.Errorf("ndkPlatformRange() = %d-%d, want 0-0 when meta/platforms.json is missing", min, max)
+	}
+}
+
+func TestLegacyNDKBundleHome(t *testing.T) {
+	t.Setenv(androidNDKHome, "")
+	t.Setenv("ANDROID_HOME", "")
+	t.Setenv("ANDROID_SDK_ROOT", "")
+	t.Setenv("HOME", "")
+
+	t.Run("prefers ANDROID_NDK_HOME", func(t *testing.T) {
+		t.Setenv(androidNDKHome, "/custom/ndk")
+		if got := legacyNDKBundleHome(); got != "/custom/ndk" {
+			t.Errorf("legacyNDKBundleHome() = %q, want %q", got, "/custom/ndk")
+		}
+	})
+
+	t.Run("falls back to ANDROID_HOME/ndk-bundle", func(t *testing.T) {
+		t.Setenv(androidNDKHome, "")
+		t.Setenv("ANDROID_HOME", "/sdk")
+		want := filepath.Join("/sdk", "ndk-bundle")
+		if got := legacyNDKBundleHome(); got != want {
+			t.Errorf("legacyNDKBundleHome() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("ANDROID_SDK_ROOT takes precedence over HOME", func(t *testing.T) {
+		t.Setenv(androidNDKHome, "")
+		t.Setenv("ANDROID_HOME", "")
+		t.Setenv("ANDROID_SDK_ROOT", "/sdk-root")
+		t.Setenv("HOME", "/home/user")
+		want := filepath.Join("/sdk-root", "ndk-bundle")
+		if got := legacyNDKBundleHome(); got != want {
+			t.Errorf("legacyNDKBundleHome() = %q, want %q", got, want)
+		}
+	})
+}
+
+// stubEnvman puts a no-op `envman` binary on $PATH, so exportNDKHome's calls to
+// tools.ExportEnvironmentWithEnvman succeed without a real Bitrise envman available.
+func stubEnvman(t *testing.T) {
+	t.Helper()
+
+	binDir := t.TempDir()
+	envmanPath := filepath.Join(binDir, "envman")
+	if err := os.WriteFile(envmanPath, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatalf("failed to write envman stub: %s", err)
+	}
+
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestUpdateNDK_AlreadyInstalledAtDiscoveredPath(t *testing.T) {
+	stubEnvman(t)
+
+	androidHome := t.TempDir()
+	legacyNdkHome := filepath.Join(androidHome, "ndk-bundle")
+	writeNDKFixture(t, legacyNdkHome, "22.1.7171670", nil, 16, 33)
+	t.Setenv("ANDROID_HOME", androidHome)
+
+	androidSdk, err := sdk.New(androidHome)
+	if err != nil {
+		t.Fatalf("failed to create sdk: %s", err)
+	}
+
+	ndk := resolvedNDK{Version: "25.1.8937393", InstalledPath: filepath.Join(androidHome, "ndk", "25.1.8937393")}
+	if err := updateNDK(ndk, androidSdk, false); err != nil {
+		t.Fatalf("updateNDK() error = %s", err)
+	}
+
+	if _, err := os.Stat(legacyNdkHome); err != nil {
+		t.Errorf("legacy NDK at %s should not be purged when the requested NDK was already discovered elsewhere", legacyNdkHome)
+	}
+}
+
+func TestUpdateNDK_AlreadyInstalledAtTargetPath(t *testing.T) {
+	stubEnvman(t)
+
+	androidHome := t.TempDir()
+	legacyNdkHome := filepath.Join(androidHome, "ndk-bundle")
+	writeNDKFixture(t, legacyNdkHome, "22.1.7171670", nil, 16, 33)
+
+	targetNDKHome := filepath.Join(androidHome, "ndk", "25.1.8937393")
+	writeNDKFixture(t, targetNDKHome, "25.1.8937393", nil, 21, 34)
+
+	androidSdk, err := sdk.New(androidHome)
+	if err != nil {
+		t.Fatalf("failed to create sdk: %s", err)
+	}
+
+	ndk := resolvedNDK{Version: "25.1.8937393"}
+	if err := updateNDK(ndk, androidSdk, false); err != nil {
+		t.Fatalf("updateNDK() error = %s", err)
+	}
+
+	if _, err := os.Stat(legacyNdkHome); err != nil {
+		t.Errorf("legacy NDK at %s should not be purged once the target version is already installed", legacyNdkHome)
+	}
+}
+
+func TestUpdateNDK_PurgesLegacyBundleUnlessKept(t *testing.T) {
+	tests := []struct {
+		name            string
+		keepExistingNDK bool
+		wantLegacyKept  bool
+	}{
+		{name: "purges legacy bundle when not kept", keepExistingNDK: false, wantLegacyKept: false},
+		{name: "keeps legacy bundle when requested", keepExistingNDK: true, wantLegacyKept: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			androidHome := t.TempDir()
+			legacyNdkHome := filepath.Join(androidHome, "ndk-bundle")
+			writeNDKFixture(t, legacyNdkHome, "22.1.7171670", nil, 16, 33)
+			t.Setenv("ANDROID_HOME", androidHome)
+
+			androidSdk, err := sdk.New(androidHome)
+			if err != nil {
+				t.Fatalf("failed to create sdk: %s", err)
+			}
+
+			ndk := resolvedNDK{Version: "25.1.8937393"}
+			// sdkmanager isn't available in the test environment, so the install step that follows the
+			// purge decision fails; what matters here is whether the purge itself ran first.
+			if err := updateNDK(ndk, androidSdk, test.keepExistingNDK); err == nil {
+				t.Fatalf("expected updateNDK() to fail past the purge step (no sdkmanager available), got nil error")
+			}
+
+			_, statErr := os.Stat(legacyNdkHome)
+			legacyKept := statErr == nil
+			if legacyKept != test.wantLegacyKept {
+				t.Errorf("legacy NDK kept = %v, want %v", legacyKept, test.wantLegacyKept)
+			}
+		})
+	}
+}